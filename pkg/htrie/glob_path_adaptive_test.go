@@ -0,0 +1,61 @@
+// Copyright (c) 2012-2019 Eli Janssen
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package htrie
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// TestCheckPathConcurrentAdaptive drives enough concurrent lookups through an
+// adaptive trie to cross hitReorderThreshold many times over, with each
+// goroutine favoring a different sibling so the hit counts (and therefore
+// the sorted order) keep shifting. That forces resortChildren to actually
+// swap nodeTree[parent] elements while other goroutines are mid-range over
+// the same slice via matchChild/checkPath. Run with -race: resortChildren
+// used to sort that slice in place with no synchronization at all.
+func TestCheckPathConcurrentAdaptive(t *testing.T) {
+	gpn := newGlobPathNode(false, true, false)
+	paths := []string{
+		"example.com/alpha/*",
+		"example.com/bravo/*",
+		"example.com/charlie/*",
+		"example.com/delta/*",
+		"example.com/echo/*",
+	}
+	for _, p := range paths {
+		if err := gpn.addPath(p); err != nil {
+			t.Fatalf("addPath(%q): %v", p, err)
+		}
+	}
+
+	lookups := []string{
+		"example.com/alpha/1",
+		"example.com/bravo/2",
+		"example.com/charlie/3",
+		"example.com/delta/4",
+		"example.com/echo/5",
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			favorite := g % len(lookups)
+			rnd := rand.New(rand.NewSource(int64(g) + 1))
+			for i := 0; i < hitReorderThreshold*32; i++ {
+				idx := favorite
+				if rnd.Intn(5) == 0 {
+					idx = rnd.Intn(len(lookups))
+				}
+				p := lookups[idx]
+				gpn.checkPath(p, 0, len(p), 0)
+			}
+		}(g)
+	}
+	wg.Wait()
+}
@@ -6,22 +6,42 @@ package htrie
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"sync/atomic"
 )
 
-const globChar uint8 = 1
+// hitReorderThreshold is how many hits a node accumulates before its
+// siblings are re-sorted by descending hit count.
+const hitReorderThreshold = 64
 
-type BitMask uint8
+const (
+	globChar     uint8 = 1
+	singleChar   uint8 = 2
+	classChar    uint8 = 3
+	paramChar    uint8 = 4 // `:name` -- matches one path segment, stops at '/'
+	catchAllChar uint8 = 5 // `*name` -- matches the remainder of the string
+)
+
+// BitMask is a uint16 so there's room for both the original glob attrs and
+// the param/catch-all attrs added alongside them.
+type BitMask uint16
 
 const (
 	isGlob BitMask = 1 << iota
 	canMatch
 	hasGlobChild
 	oneShot
+	hasSingleChild
+	hasClassChild
+	hasParamChild
+	hasCatchAllChild
+	isParam
+	isCatchAll
 )
 
 func (m BitMask) String() string {
-	if m >= oneShot {
+	if m >= isCatchAll<<1 {
 		return fmt.Sprintf("<unknown key: %d>", m)
 	}
 
@@ -34,11 +54,23 @@ func (m BitMask) String() string {
 		return "glob-child"
 	case oneShot:
 		return "one-shot"
+	case hasSingleChild:
+		return "single-child"
+	case hasClassChild:
+		return "class-child"
+	case hasParamChild:
+		return "param-child"
+	case hasCatchAllChild:
+		return "catch-all-child"
+	case isParam:
+		return "param"
+	case isCatchAll:
+		return "catch-all"
 	}
 
 	// multiple keys
 	var names []string
-	for key := isGlob; key < oneShot; key <<= 1 {
+	for key := isGlob; key < isCatchAll<<1; key <<= 1 {
 		if m&key != 0 {
 			names = append(names, key.String())
 		}
@@ -46,73 +78,425 @@ func (m BitMask) String() string {
 	return strings.Join(names, "|")
 }
 
-// A globPathNode represents a path checker that supports globbing comparisons
+// classSet is a 256-bit bitmap used by bracket character classes (`[a-z0-9]`,
+// `[^abc]`) to answer "is this byte a member" in constant time.
+type classSet [4]uint64
+
+func (cs *classSet) add(b byte) {
+	cs[b>>6] |= 1 << (b & 63)
+}
+
+func (cs *classSet) has(b byte) bool {
+	return cs[b>>6]&(1<<(b&63)) != 0
+}
+
+func (cs *classSet) negate() {
+	for i := range cs {
+		cs[i] = ^cs[i]
+	}
+}
+
+func (cs *classSet) equal(other *classSet) bool {
+	if cs == nil || other == nil {
+		return cs == other
+	}
+	return *cs == *other
+}
+
+// pathToken is an intermediate representation of a single trie step produced
+// by tokenizing a rule string. It exists so that `addPath` can resolve brace
+// alternation and bracket classes before ever touching the trie itself.
+type pathToken struct {
+	ch    uint8
+	class *classSet
+	name  string // set for paramChar/catchAllChar tokens
+}
+
+// Param is one named capture produced by checkPathCapture, e.g. the `uid` in
+// a rule like `cdn.example.com/user/:uid/avatar`.
+type Param struct {
+	Key   string
+	Value string
+}
+
+// childSet is a lock-free, copy-on-write handle to a node's child-index
+// list. checkPath/globConsume (and their capture variants) run concurrently
+// across goroutines and read a node's children on every hot-path lookup,
+// while recordHit/resortChildren reorders them in the background as hit
+// counts cross hitReorderThreshold. A plain []int field would let a reader's
+// range loop observe a slice being sorted out from under it; childSet
+// instead has resortChildren build a freshly sorted copy and swap the
+// pointer in atomically, so every reader sees either the pre- or
+// post-reorder slice in full, never a partial one.
+type childSet = atomic.Pointer[[]int]
+
+// A globPathNode represents a path checker that supports globbing comparisons.
+//
+// The trie is radix-compressed: a run of literal bytes with no branching and
+// no glob/param boundary is stored as a single node whose nodeLabel holds the
+// whole run, rather than one node per byte. nodeChars still holds the first
+// byte of that label (or the relevant sentinel for `*`/`?`/class nodes) so
+// sibling lookup stays a simple byte compare; nodeLabel is nil for anything
+// that isn't a literal run.
 type globPathNode struct {
-	nodeChars []uint8
-	nodeAttrs []BitMask
-	nodeTree  [][]int
-	icase     bool
+	nodeChars   []uint8
+	nodeAttrs   []BitMask
+	nodeTree    []childSet
+	nodeLabel   [][]byte
+	nodeClasses []*classSet
+	// nodeParams holds the capture name for isParam/isCatchAll nodes (`:uid`,
+	// `*rest`), empty for every other node.
+	nodeParams []string
+	// nodeHits counts, only when adaptive is set, how often a lookup steps
+	// into each node (a literal label is counted as soon as it matches, even
+	// if the rest of the path then fails). It drives periodic reordering of
+	// nodeTree siblings so hot branches are tried first; see
+	// recordHit/resortChildren.
+	nodeHits []uint32
+	icase    bool
+	adaptive bool
+	// captures gates whether `:name`/`*name` are parsed as named params/
+	// catch-all at all (see tokenizePath). It defaults to off so that a
+	// ruleset written against the original bare-`*` grammar -- where a
+	// literal identifier run after `*` or a literal `:` anywhere in the
+	// string was never special -- keeps matching exactly as before; a
+	// caller only gets the new grammar by asking for it.
+	captures bool
+	// sourceHash is an opaque content hash of the ruleset this trie was
+	// built from; see SetSourceHash/SourceHash in glob_path_serialize.go.
+	sourceHash uint64
 }
 
+// addPath inserts a rule into the trie. In addition to literal bytes and `*`
+// (match anything, greedily), it understands:
+//
+//   - `?`        matches exactly one arbitrary byte
+//   - `[...]`    matches one byte from a class, e.g. `[a-z0-9]`, `[^0-9]`
+//   - `{a,b,c}`  brace alternation, expanded at insert time into one addPath
+//     call per alternative so no runtime backtracking is required
+//   - `\x`       escapes `x`, so literal `*`, `?`, `[`, `{`, `:`, `\` can be matched
+//
+// If the trie was built with captures enabled (see newGlobPathNode), it also
+// understands, anchored to the start of a path segment -- the start of the
+// pattern, right after a `/`, or right after a bare `*` glob (which already
+// consumes arbitrary content, so there's no pre-existing literal text there
+// to reinterpret) -- anywhere else `:` and `*` followed by an identifier keep
+// their plain meaning, a literal `:` and a bare glob:
+//
+//   - `:name`    matches one path segment (stops at `/`), captured as name
+//   - `*name`    matches the rest of the string, captured as name; since it
+//     always consumes through the end, it must be the last component of the
+//     pattern -- addPath rejects anything following it
 func (gpn *globPathNode) addPath(s string) error {
 	if gpn == nil {
 		return fmt.Errorf("got nil <gpn> in receiver")
 	}
 
-	mlen := len(s)
-	prevnode := 0
-	curnode := 0
-	nextnode := 0
-	//for _, part := range s {
-	for i := 0; i < mlen; i++ {
-		part := uint8(s[i])
+	if start, end, found, err := findBraceGroup(s); err != nil {
+		return err
+	} else if found {
+		prefix := s[:start]
+		suffix := s[end+1:]
+		for _, alt := range splitUnescaped(s[start+1:end], ',') {
+			if err := gpn.addPath(prefix + alt + suffix); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 
-		// if icase, use lowercase letters for comparisons
-		// 'A' == 65; 'Z' == 90
-		if gpn.icase && 65 <= part && part <= 90 {
-			part = part + 32
+	tokens, err := tokenizePath(s, gpn.icase, gpn.captures)
+	if err != nil {
+		return err
+	}
+
+	return gpn.insertTokens(tokens)
+}
+
+// scanClassSpan returns the index of the `]` that closes the bracket class
+// starting at s[open] == '[' (or len(s) if it's unterminated), tracking just
+// enough of the class grammar -- a leading `^`, a literal `]` as the very
+// first member, and `\`-escapes -- to skip over it without mistaking a `{`,
+// `}`, or `,` inside the class for a brace-alternation delimiter. It doesn't
+// parse ranges or build a classSet; parseClass does that once tokenizePath
+// actually reaches the class.
+func scanClassSpan(s string, open int) int {
+	i := open + 1
+	if i < len(s) && s[i] == '^' {
+		i++
+	}
+	first := true
+	for i < len(s) && (s[i] != ']' || first) {
+		first = false
+		if s[i] == '\\' {
+			i++
+			if i >= len(s) {
+				return len(s)
+			}
 		}
+		i++
+	}
+	if i >= len(s) {
+		return len(s)
+	}
+	return i
+}
 
-		var c uint8
-		// '*' == 42
-		if part == 42 {
-			c = globChar
-		} else {
-			c = part
+// findBraceGroup locates the first unescaped `{...}` brace group in s. It
+// does not support nested braces, matching the simple alternation grammar
+// of libraries like gobwas/glob. `[...]` bracket classes are skipped whole,
+// so a literal `{` or `}` inside one (e.g. `[{]`) isn't mistaken for a brace
+// delimiter.
+func findBraceGroup(s string) (start, end int, found bool, err error) {
+	start = -1
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '[':
+			i = scanClassSpan(s, i)
+		case '{':
+			if start == -1 {
+				start = i
+			}
+		case '}':
+			if start != -1 {
+				return start, i, true, nil
+			}
 		}
+	}
+	if start != -1 {
+		return 0, 0, false, fmt.Errorf("unterminated brace group in pattern %q", s)
+	}
+	return 0, 0, false, nil
+}
 
-		// subt[c] == nil
-		found := false
-		for subTreeIndex := range gpn.nodeTree[curnode] {
-			idx := gpn.nodeTree[curnode][subTreeIndex]
-			if gpn.nodeChars[idx] == c {
-				nextnode = int(idx)
-				found = true
-				break
+// splitUnescaped splits s on sep, ignoring any sep preceded by a backslash
+// escape or that falls inside a `[...]` bracket class.
+func splitUnescaped(s string, sep byte) []string {
+	var parts []string
+	last := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '[':
+			i = scanClassSpan(s, i)
+		case sep:
+			parts = append(parts, s[last:i])
+			last = i + 1
+		}
+	}
+	parts = append(parts, s[last:])
+	return parts
+}
+
+// tokenizePath converts a single (brace-free) rule string into the sequence
+// of trie steps it represents. captures gates whether `:name`/`*name` are
+// recognized at all, and even then only at a path-segment boundary (see
+// atSegmentStart) -- see the capture-related doc on addPath/newGlobPathNode
+// for why: a plain identifier run after `*` or a literal `:` must keep
+// meaning exactly what it meant before named captures existed.
+func tokenizePath(s string, icase bool, captures bool) ([]pathToken, error) {
+	var tokens []pathToken
+	mlen := len(s)
+	for i := 0; i < mlen; i++ {
+		part := s[i]
+		switch part {
+		case '\\':
+			i++
+			if i >= mlen {
+				return nil, fmt.Errorf("dangling escape at end of pattern %q", s)
+			}
+			tokens = append(tokens, pathToken{ch: foldCase(s[i], icase)})
+		case '*':
+			name, next := "", i+1
+			if captures && atSegmentStart(s, i) {
+				name, next = scanParamName(s, i+1)
+			}
+			if name != "" {
+				if next != mlen {
+					return nil, fmt.Errorf("catch-all capture `*%s` must be the last component of pattern %q", name, s)
+				}
+				tokens = append(tokens, pathToken{ch: catchAllChar, name: name})
+				i = next - 1
+			} else {
+				tokens = append(tokens, pathToken{ch: globChar})
+			}
+		case ':':
+			if !captures || !atSegmentStart(s, i) {
+				tokens = append(tokens, pathToken{ch: foldCase(part, icase)})
+				continue
+			}
+			name, next := scanParamName(s, i+1)
+			if name == "" {
+				return nil, fmt.Errorf("expected a param name after ':' in pattern %q", s)
 			}
+			tokens = append(tokens, pathToken{ch: paramChar, name: name})
+			i = next - 1
+		case '?':
+			tokens = append(tokens, pathToken{ch: singleChar})
+		case '[':
+			cs, next, err := parseClass(s, i+1, icase)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, pathToken{ch: classChar, class: cs})
+			i = next
+		default:
+			tokens = append(tokens, pathToken{ch: foldCase(part, icase)})
 		}
-		if !found {
-			gpn.nodeTree = append(gpn.nodeTree, make([]int, 0))
-			gpn.nodeAttrs = append(gpn.nodeAttrs, 0)
-			gpn.nodeChars = append(gpn.nodeChars, c)
-			newIdx := len(gpn.nodeChars) - 1
-			gpn.nodeTree[curnode] = append(gpn.nodeTree[curnode], newIdx)
-			nextnode = newIdx
+	}
+	return tokens, nil
+}
+
+// atSegmentStart reports whether index i in s begins a path segment, i.e. is
+// the first byte of the pattern, immediately follows a `/`, or immediately
+// follows a bare `*` glob. Named captures are only recognized there, so a
+// literal `:`/`*` elsewhere in the pattern (a port number, a filename suffix)
+// never gets reinterpreted. The glob case covers a rule like "static/*:id"
+// or "static/**rest": a `*` already consumes arbitrary content up to that
+// point, so there's no pre-existing literal text there for a capture to
+// silently reinterpret -- unlike the hostname/filename case this function
+// otherwise guards against.
+func atSegmentStart(s string, i int) bool {
+	if i == 0 || s[i-1] == '/' {
+		return true
+	}
+	if s[i-1] != '*' {
+		return false
+	}
+	// a `\*` is a literal asterisk, not a glob: an odd run of backslashes
+	// right before the `*` means it was escaped.
+	n := 0
+	for j := i - 2; j >= 0 && s[j] == '\\'; j-- {
+		n++
+	}
+	return n%2 == 0
+}
+
+// scanParamName scans a `:name`/`*name` identifier starting at index i,
+// returning it (possibly empty, if i doesn't start an identifier) along with
+// the index just past it.
+func scanParamName(s string, i int) (string, int) {
+	start := i
+	for i < len(s) && isIdentByte(s[i]) {
+		i++
+	}
+	return s[start:i], i
+}
+
+func isIdentByte(b byte) bool {
+	return b == '_' ||
+		(b >= 'a' && b <= 'z') ||
+		(b >= 'A' && b <= 'Z') ||
+		(b >= '0' && b <= '9')
+}
+
+// foldCase lowercases an ASCII byte when icase is set, mirroring the
+// case-folding checkPath/globConsume already apply to input bytes.
+func foldCase(part uint8, icase bool) uint8 {
+	if icase && 65 <= part && part <= 90 {
+		return part + 32
+	}
+	return part
+}
+
+// parseClass parses a `[...]` bracket expression starting just after the
+// opening `[` (index i) and returns the resulting classSet along with the
+// index of the closing `]`.
+func parseClass(s string, i int, icase bool) (*classSet, int, error) {
+	mlen := len(s)
+	negate := false
+	if i < mlen && s[i] == '^' {
+		negate = true
+		i++
+	}
+
+	cs := &classSet{}
+	first := true
+	for i < mlen && (s[i] != ']' || first) {
+		first = false
+		lo := s[i]
+		if lo == '\\' {
+			i++
+			if i >= mlen {
+				return nil, 0, fmt.Errorf("dangling escape in character class %q", s)
+			}
+			lo = s[i]
 		}
 
-		// setup oneshot as an optimizaiton if there is only one subcandidate...
-		if len(gpn.nodeTree[curnode]) == 1 {
-			gpn.nodeAttrs[curnode] |= oneShot
-		} else {
-			gpn.nodeAttrs[curnode] &^= oneShot
+		if i+2 < mlen && s[i+1] == '-' && s[i+2] != ']' {
+			hi := s[i+2]
+			hiLen := 3
+			if hi == '\\' && i+3 < mlen {
+				hi = s[i+3]
+				hiLen = 4
+			}
+			if hi < lo {
+				return nil, 0, fmt.Errorf("invalid character range %c-%c in pattern %q", lo, hi, s)
+			}
+			for b := int(lo); b <= int(hi); b++ {
+				addClassByte(cs, byte(b), icase)
+			}
+			i += hiLen
+			continue
 		}
 
-		prevnode = curnode
-		curnode = nextnode
-		if c == globChar {
-			gpn.nodeAttrs[prevnode] |= hasGlobChild
-			gpn.nodeAttrs[curnode] |= isGlob
+		addClassByte(cs, lo, icase)
+		i++
+	}
+
+	if i >= mlen || s[i] != ']' {
+		return nil, 0, fmt.Errorf("unterminated character class in pattern %q", s)
+	}
+
+	if negate {
+		cs.negate()
+	}
+
+	return cs, i, nil
+}
+
+// addClassByte adds b to cs, also adding its opposite-case counterpart when
+// icase is set so class membership agrees with the case-folding done
+// elsewhere in the trie.
+func addClassByte(cs *classSet, b byte, icase bool) {
+	cs.add(b)
+	if !icase {
+		return
+	}
+	switch {
+	case b >= 'a' && b <= 'z':
+		cs.add(b - 32)
+	case b >= 'A' && b <= 'Z':
+		cs.add(b + 32)
+	}
+}
+
+// insertTokens walks tokens into the trie, batching consecutive literal
+// bytes into a single radix-compressed label and inserting `*`/`?`/class
+// tokens as their own node, same as before.
+func (gpn *globPathNode) insertTokens(tokens []pathToken) error {
+	curnode := 0
+
+	i := 0
+	for i < len(tokens) {
+		if isLiteral(tokens[i]) {
+			start := i
+			for i < len(tokens) && isLiteral(tokens[i]) {
+				i++
+			}
+			label := make([]byte, i-start)
+			for k := start; k < i; k++ {
+				label[k-start] = tokens[k].ch
+			}
+			curnode = gpn.insertLabel(curnode, label)
+			continue
 		}
+
+		curnode = gpn.insertSpecial(curnode, tokens[i])
+		i++
 	}
 
 	// this is the end of the path, so this node can be a match, even if future
@@ -121,6 +505,220 @@ func (gpn *globPathNode) addPath(s string) error {
 	return nil
 }
 
+func isLiteral(tok pathToken) bool {
+	switch tok.ch {
+	case globChar, singleChar, classChar, paramChar, catchAllChar:
+		return false
+	}
+	return true
+}
+
+// newNode appends a fresh, unlinked node to the parallel slices and returns
+// its index. firstByte is the byte sibling lookups key off: a label's first
+// byte for literal nodes, or the relevant sentinel for `*`/`?`/class nodes.
+func (gpn *globPathNode) newNode(firstByte uint8) int {
+	gpn.nodeTree = append(gpn.nodeTree, childSet{})
+	gpn.nodeAttrs = append(gpn.nodeAttrs, 0)
+	gpn.nodeChars = append(gpn.nodeChars, firstByte)
+	gpn.nodeLabel = append(gpn.nodeLabel, nil)
+	gpn.nodeClasses = append(gpn.nodeClasses, nil)
+	gpn.nodeParams = append(gpn.nodeParams, "")
+	gpn.nodeHits = append(gpn.nodeHits, 0)
+	return len(gpn.nodeChars) - 1
+}
+
+// children returns curnode's child-index list. Safe to call concurrently
+// with resortChildren; see childSet.
+func (gpn *globPathNode) children(curnode int) []int {
+	p := gpn.nodeTree[curnode].Load()
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+// setChildren atomically replaces curnode's child-index list.
+func (gpn *globPathNode) setChildren(curnode int, children []int) {
+	gpn.nodeTree[curnode].Store(&children)
+}
+
+// recordHit bumps idx's hit counter and, once adaptive ordering is enabled
+// and the counter crosses hitReorderThreshold, resorts parent's children so
+// the hottest branches are tried first on the next lookup. It's a no-op
+// unless adaptive is set, so deterministic benchmarks/tests can opt out.
+func (gpn *globPathNode) recordHit(parent, idx int) {
+	if !gpn.adaptive {
+		return
+	}
+	hits := atomic.AddUint32(&gpn.nodeHits[idx], 1)
+	if hits%hitReorderThreshold == 0 {
+		gpn.resortChildren(parent)
+	}
+}
+
+// resortChildren reorders parent's child list in descending hit-count order,
+// the same "hottest branch first" idea as httprouter's incrementChildPrio.
+// It never mutates the live slice in place: concurrent readers may be
+// ranging over it via children(), so a fresh, sorted copy is built and
+// swapped in atomically instead.
+func (gpn *globPathNode) resortChildren(parent int) {
+	children := append([]int(nil), gpn.children(parent)...)
+	sort.SliceStable(children, func(a, b int) bool {
+		return atomic.LoadUint32(&gpn.nodeHits[children[a]]) > atomic.LoadUint32(&gpn.nodeHits[children[b]])
+	})
+	gpn.setChildren(parent, children)
+}
+
+// attachChild links newIdx under curnode and keeps the oneShot optimization
+// (exactly one subcandidate) up to date.
+func (gpn *globPathNode) attachChild(curnode, newIdx int) {
+	children := append(append([]int(nil), gpn.children(curnode)...), newIdx)
+	gpn.setChildren(curnode, children)
+	if len(children) == 1 {
+		gpn.nodeAttrs[curnode] |= oneShot
+	} else {
+		gpn.nodeAttrs[curnode] &^= oneShot
+	}
+}
+
+// insertLabel inserts a run of literal bytes under curnode, splitting any
+// existing label node at the point where it diverges from label (the
+// standard radix-tree "common-prefix split", as in httprouter's addRoute),
+// and returns the node the run ends on.
+func (gpn *globPathNode) insertLabel(curnode int, label []byte) int {
+	for len(label) > 0 {
+		matchIdx := -1
+		for _, idx := range gpn.children(curnode) {
+			if gpn.nodeChars[idx] == label[0] && gpn.nodeLabel[idx] != nil {
+				matchIdx = idx
+				break
+			}
+		}
+
+		if matchIdx == -1 {
+			newIdx := gpn.newNode(label[0])
+			gpn.nodeLabel[newIdx] = append([]byte(nil), label...)
+			gpn.attachChild(curnode, newIdx)
+			return newIdx
+		}
+
+		cp := commonPrefixLen(gpn.nodeLabel[matchIdx], label)
+		if cp < len(gpn.nodeLabel[matchIdx]) {
+			gpn.splitLabelNode(matchIdx, cp)
+		}
+		curnode = matchIdx
+		label = label[cp:]
+	}
+	return curnode
+}
+
+// splitLabelNode splits the label node at idx into a prefix (label[:cp], kept
+// at idx) and a new tail node (label[cp:]) that inherits idx's old attrs and
+// children. idx's own index is reused for the prefix so callers
+// that already reference idx need no update.
+func (gpn *globPathNode) splitLabelNode(idx, cp int) {
+	label := gpn.nodeLabel[idx]
+	prefix := label[:cp]
+	suffix := label[cp:]
+
+	tailIdx := gpn.newNode(suffix[0])
+	gpn.nodeLabel[tailIdx] = suffix
+	gpn.nodeAttrs[tailIdx] = gpn.nodeAttrs[idx]
+	gpn.setChildren(tailIdx, gpn.children(idx))
+
+	gpn.nodeChars[idx] = prefix[0]
+	gpn.nodeLabel[idx] = prefix
+	gpn.nodeAttrs[idx] = 0
+	gpn.setChildren(idx, nil)
+	// route through attachChild rather than setChildren directly, so idx
+	// picks up the oneShot bit attachChild sets for a lone child -- without
+	// it, every split would permanently fall back to the slow map-lookup
+	// path instead of the fast path this radix compression exists to give.
+	gpn.attachChild(idx, tailIdx)
+}
+
+// commonPrefixLen returns the length of the shared prefix of a and b.
+func commonPrefixLen(a, b []byte) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+// insertSpecial inserts a single `*`/`?`/class/`:name`/`*name` token as its
+// own node under curnode (these never participate in radix compression) and
+// returns it.
+func (gpn *globPathNode) insertSpecial(curnode int, tok pathToken) int {
+	c := tok.ch
+
+	for _, idx := range gpn.children(curnode) {
+		if gpn.nodeChars[idx] != c {
+			continue
+		}
+		if c == classChar && !tok.class.equal(gpn.nodeClasses[idx]) {
+			continue
+		}
+		if (c == paramChar || c == catchAllChar) && gpn.nodeParams[idx] != tok.name {
+			continue
+		}
+		return idx
+	}
+
+	newIdx := gpn.newNode(c)
+	gpn.nodeClasses[newIdx] = tok.class
+	gpn.nodeParams[newIdx] = tok.name
+	gpn.attachChild(curnode, newIdx)
+
+	switch c {
+	case globChar:
+		gpn.nodeAttrs[curnode] |= hasGlobChild
+		gpn.nodeAttrs[newIdx] |= isGlob
+	case singleChar:
+		gpn.nodeAttrs[curnode] |= hasSingleChild
+	case classChar:
+		gpn.nodeAttrs[curnode] |= hasClassChild
+	case paramChar:
+		gpn.nodeAttrs[curnode] |= hasParamChild
+		gpn.nodeAttrs[newIdx] |= isParam
+	case catchAllChar:
+		gpn.nodeAttrs[curnode] |= hasCatchAllChild
+		gpn.nodeAttrs[newIdx] |= isCatchAll
+	}
+	return newIdx
+}
+
+// matchChild finds a literal/label child of curnode whose label begins with
+// part. Sentinel (`*`/`?`/class) children are never returned here; callers
+// check hasGlobChild/hasSingleChild/hasClassChild separately.
+func (gpn *globPathNode) matchChild(curnode int, part uint8) (int, bool) {
+	for _, idx := range gpn.children(curnode) {
+		if gpn.nodeChars[idx] == part && gpn.nodeLabel[idx] != nil {
+			return idx, true
+		}
+	}
+	return 0, false
+}
+
+// labelMatches reports whether s[i:] starts with label, case-folding each
+// input byte the same way the rest of the trie does.
+func (gpn *globPathNode) labelMatches(s string, i, mlen int, label []byte) bool {
+	if i+len(label) > mlen {
+		return false
+	}
+	for k, b := range label {
+		if foldCase(uint8(s[i+k]), gpn.icase) != b {
+			return false
+		}
+	}
+	return true
+}
+
 func (gpn *globPathNode) globConsume(s string, index, mlen, nodeIndex int) bool {
 	curnode := nodeIndex
 
@@ -130,6 +728,14 @@ func (gpn *globPathNode) globConsume(s string, index, mlen, nodeIndex int) bool
 		return true
 	}
 
+	// a catch-all child always matches the remainder of the string
+	// (including zero bytes of it), so a glob immediately followed by
+	// `*name` (e.g. "static/**rest") always matches, regardless of where
+	// the glob would otherwise have stopped.
+	if gpn.nodeAttrs[curnode]&hasCatchAllChild != 0 {
+		return true
+	}
+
 	// otherwise we have some work to do...
 	// don't need to iter runes since we have ascii
 	for i := index; i < mlen; i++ {
@@ -137,43 +743,73 @@ func (gpn *globPathNode) globConsume(s string, index, mlen, nodeIndex int) bool
 
 		// if icase, use lowercase letters for comparisons
 		// 'A' == 65; 'Z' == 90
-		if gpn.icase && 65 <= part && part <= 90 {
-			part = part + 32
-		}
-
-		x := gpn.nodeChars[curnode]
-		if x == globChar {
-			x = '*'
-		}
-		nextX := gpn.nodeChars[gpn.nodeTree[curnode][0]]
-		if nextX == globChar {
-			nextX = '*'
-		}
+		part = foldCase(part, gpn.icase)
 
 		// optimize common single char after * globbing
 		// eg. .../*/...
 		// if we know the glob has one one subcandidate (next char), we consume until
 		// we hit one of those
-		if gpn.nodeAttrs[curnode]&oneShot != 0 && len(gpn.nodeTree[curnode]) > 0 {
-			idx := gpn.nodeTree[curnode][0]
-			if part != gpn.nodeChars[idx] {
+		//
+		// this only applies when that subcandidate is a literal byte: for a
+		// `?`/class/`:name`/`*name` child, nodeChars[idx] is a sentinel that
+		// a real path byte can never equal, so comparing part against it
+		// would skip every byte and never fall through to the handling for
+		// those node kinds below.
+		children := gpn.children(curnode)
+		if gpn.nodeAttrs[curnode]&oneShot != 0 && len(children) > 0 {
+			idx := children[0]
+			if gpn.nodeLabel[idx] != nil && part != gpn.nodeChars[idx] {
 				continue
 			}
 		}
 
-		for j := range gpn.nodeTree[curnode] {
-			idx := gpn.nodeTree[curnode][j]
-			if gpn.nodeChars[idx] == part {
-				// found a candidate. follow it with normal branch logic.
-				// if it matches, we're done!
-				// increment index value for checkPath because we consumed a char
-				// by following oneShot
+		if idx, found := gpn.matchChild(curnode, part); found {
+			label := gpn.nodeLabel[idx]
+			// found a candidate. follow it with normal branch logic.
+			// if it matches, we're done!
+			// advance past the whole label, since we consumed it by following
+			// oneShot
+			if gpn.labelMatches(s, i, mlen, label) && gpn.checkPath(s, i+len(label), mlen, idx) {
+				gpn.recordHit(curnode, idx)
+				return true
+			}
+		}
+
+		// `?` and bracket-class children also consume this byte; try them
+		// the same way a literal candidate would be tried above.
+		for _, idx := range gpn.children(curnode) {
+			switch gpn.nodeChars[idx] {
+			case singleChar:
 				if gpn.checkPath(s, i+1, mlen, idx) {
+					gpn.recordHit(curnode, idx)
+					return true
+				}
+			case classChar:
+				if gpn.nodeClasses[idx].has(part) && gpn.checkPath(s, i+1, mlen, idx) {
+					gpn.recordHit(curnode, idx)
 					return true
 				}
 			}
 		}
 
+		// a `:name` child also consumes this byte (and every byte up to the
+		// next `/`), the same way a literal/`?`/class candidate is tried
+		// above; try it at each position the glob could plausibly stop.
+		if gpn.nodeAttrs[curnode]&hasParamChild != 0 {
+			for _, idx := range gpn.children(curnode) {
+				if gpn.nodeChars[idx] == paramChar {
+					end := i
+					for end < mlen && s[end] != '/' {
+						end++
+					}
+					if end > i && gpn.checkPath(s, end, mlen, idx) {
+						gpn.recordHit(curnode, idx)
+						return true
+					}
+				}
+			}
+		}
+
 		// was this the last char in path?
 		if i == mlen-1 {
 			// reached the end without a match, and the glob wasn't at the end
@@ -195,9 +831,7 @@ func (gpn *globPathNode) checkPath(s string, index, mlen int, nodeIndex int) boo
 
 		// if icase, use lowercase letters for comparisons
 		// 'A' == 65; 'Z' == 90
-		if gpn.icase && 65 <= part && part <= 90 {
-			part = part + 32
-		}
+		part = foldCase(part, gpn.icase)
 
 		// node may have a glob child candidate (consumes), check that first
 		if gpn.nodeAttrs[curnode]&hasGlobChild != 0 {
@@ -205,29 +839,89 @@ func (gpn *globPathNode) checkPath(s string, index, mlen int, nodeIndex int) boo
 			// don't advance string pointer here though, as a glob is a match
 			// node and not a standard char node (it can also match zero characters)
 			/// find glob child
-			for j := range gpn.nodeTree[curnode] {
-				idx := gpn.nodeTree[curnode][j]
+			for _, idx := range gpn.children(curnode) {
 				if gpn.nodeChars[idx] == globChar {
 					// found our node
 					if gpn.globConsume(s, i, mlen, idx) {
+						gpn.recordHit(curnode, idx)
 						return true
 					}
 				}
 			}
 		}
 
+		// node may have a `[...]` class child candidate; a class consumes
+		// exactly one byte, so try it before falling back to oneshot/map.
+		if gpn.nodeAttrs[curnode]&hasClassChild != 0 {
+			for _, idx := range gpn.children(curnode) {
+				if gpn.nodeChars[idx] == classChar && gpn.nodeClasses[idx].has(part) {
+					if gpn.checkPath(s, i+1, mlen, idx) {
+						gpn.recordHit(curnode, idx)
+						return true
+					}
+				}
+			}
+		}
+
+		// node may have a `?` child candidate; like class, it consumes
+		// exactly one byte, matching anything.
+		if gpn.nodeAttrs[curnode]&hasSingleChild != 0 {
+			for _, idx := range gpn.children(curnode) {
+				if gpn.nodeChars[idx] == singleChar {
+					if gpn.checkPath(s, i+1, mlen, idx) {
+						gpn.recordHit(curnode, idx)
+						return true
+					}
+				}
+			}
+		}
+
+		// node may have a `:name` child candidate; it consumes up to the next
+		// '/' (or the end of the string), never zero bytes.
+		if gpn.nodeAttrs[curnode]&hasParamChild != 0 {
+			for _, idx := range gpn.children(curnode) {
+				if gpn.nodeChars[idx] == paramChar {
+					end := i
+					for end < mlen && s[end] != '/' {
+						end++
+					}
+					if end > i && gpn.checkPath(s, end, mlen, idx) {
+						gpn.recordHit(curnode, idx)
+						return true
+					}
+				}
+			}
+		}
+
+		// node may have a `*name` catch-all child candidate; it consumes the
+		// rest of the string and always matches. addPath rejects any pattern
+		// that attaches further components after a catch-all, so reaching
+		// this node always means the whole remainder is a legitimate match.
+		if gpn.nodeAttrs[curnode]&hasCatchAllChild != 0 {
+			for _, idx := range gpn.children(curnode) {
+				if gpn.nodeChars[idx] == catchAllChar {
+					gpn.recordHit(curnode, idx)
+					return true
+				}
+			}
+		}
+
 		// oneshot means we only have one child candidate -- an optimization (fastpath)
 		// to avoid the slow path map fallback
 		if gpn.nodeAttrs[curnode]&oneShot != 0 {
-			// only one candidate, and it _was_ the glob we tried.
-			// we're done!
-			idx := gpn.nodeTree[curnode][0]
-			if gpn.nodeChars[idx] == globChar { // or gpn.nodeAttrs[idx][0] (isGlob)
+			// only one candidate, and it _was_ one of the special nodes we
+			// already tried above. we're done!
+			idx := gpn.children(curnode)[0]
+			switch gpn.nodeChars[idx] {
+			case globChar, singleChar, classChar, paramChar, catchAllChar: // or gpn.nodeAttrs[idx][0] (isGlob)
 				return false
 			}
 
-			// if oneshot matches, use it
-			if gpn.nodeChars[idx] == part {
+			// if oneshot matches, walk the whole label and use it
+			label := gpn.nodeLabel[idx]
+			if gpn.labelMatches(s, i, mlen, label) {
+				gpn.recordHit(curnode, idx)
+				i += len(label) - 1 // loop increment covers the last byte
 				curnode = idx
 				continue
 			}
@@ -239,18 +933,17 @@ func (gpn *globPathNode) checkPath(s string, index, mlen int, nodeIndex int) boo
 
 		// more than one candidate, so fallback to map lookup, since we don't
 		// know anything else
-		found := false
-		for j := range gpn.nodeTree[curnode] {
-			idx := gpn.nodeTree[curnode][j]
-			if gpn.nodeChars[idx] == part {
-				curnode = idx
-				found = true
-				break
-			}
-		}
+		idx, found := gpn.matchChild(curnode, part)
 		if !found {
 			return false
 		}
+		label := gpn.nodeLabel[idx]
+		if !gpn.labelMatches(s, i, mlen, label) {
+			return false
+		}
+		gpn.recordHit(curnode, idx)
+		i += len(label) - 1 // loop increment covers the last byte
+		curnode = idx
 	}
 
 	// reached the end of the string.. check if curnode is a leaf or globby
@@ -258,7 +951,209 @@ func (gpn *globPathNode) checkPath(s string, index, mlen int, nodeIndex int) boo
 	return gpn.nodeAttrs[curnode]&(isGlob|canMatch) != 0
 }
 
-func newGlobPathNode(icase bool) *globPathNode {
+// checkPathCapture behaves like checkPath(s, 0, len(s), 0) but also returns
+// the named `:param`/`*catchall` captures along the winning path, for
+// callers that need them (logging, per-tenant rate limiting, rewrites).
+func (gpn *globPathNode) checkPathCapture(s string) (bool, []Param) {
+	return gpn.checkPathCaptureNode(s, 0, len(s), 0, nil)
+}
+
+func (gpn *globPathNode) checkPathCaptureNode(s string, index, mlen, nodeIndex int, params []Param) (bool, []Param) {
+	curnode := nodeIndex
+	for i := index; i < mlen; i++ {
+		part := foldCase(uint8(s[i]), gpn.icase)
+
+		if gpn.nodeAttrs[curnode]&hasGlobChild != 0 {
+			for _, idx := range gpn.children(curnode) {
+				if gpn.nodeChars[idx] == globChar {
+					if ok, p := gpn.globConsumeCapture(s, i, mlen, idx, params); ok {
+						gpn.recordHit(curnode, idx)
+						return true, p
+					}
+				}
+			}
+		}
+
+		if gpn.nodeAttrs[curnode]&hasClassChild != 0 {
+			for _, idx := range gpn.children(curnode) {
+				if gpn.nodeChars[idx] == classChar && gpn.nodeClasses[idx].has(part) {
+					if ok, p := gpn.checkPathCaptureNode(s, i+1, mlen, idx, params); ok {
+						gpn.recordHit(curnode, idx)
+						return true, p
+					}
+				}
+			}
+		}
+
+		if gpn.nodeAttrs[curnode]&hasSingleChild != 0 {
+			for _, idx := range gpn.children(curnode) {
+				if gpn.nodeChars[idx] == singleChar {
+					if ok, p := gpn.checkPathCaptureNode(s, i+1, mlen, idx, params); ok {
+						gpn.recordHit(curnode, idx)
+						return true, p
+					}
+				}
+			}
+		}
+
+		if gpn.nodeAttrs[curnode]&hasParamChild != 0 {
+			for _, idx := range gpn.children(curnode) {
+				if gpn.nodeChars[idx] == paramChar {
+					end := i
+					for end < mlen && s[end] != '/' {
+						end++
+					}
+					if end == i {
+						continue
+					}
+					captured := append(append([]Param(nil), params...), Param{Key: gpn.nodeParams[idx], Value: s[i:end]})
+					if ok, p := gpn.checkPathCaptureNode(s, end, mlen, idx, captured); ok {
+						gpn.recordHit(curnode, idx)
+						return true, p
+					}
+				}
+			}
+		}
+
+		if gpn.nodeAttrs[curnode]&hasCatchAllChild != 0 {
+			for _, idx := range gpn.children(curnode) {
+				if gpn.nodeChars[idx] == catchAllChar {
+					gpn.recordHit(curnode, idx)
+					captured := append(append([]Param(nil), params...), Param{Key: gpn.nodeParams[idx], Value: s[i:mlen]})
+					return true, captured
+				}
+			}
+		}
+
+		if gpn.nodeAttrs[curnode]&oneShot != 0 {
+			idx := gpn.children(curnode)[0]
+			switch gpn.nodeChars[idx] {
+			case globChar, singleChar, classChar, paramChar, catchAllChar:
+				return false, params
+			}
+
+			label := gpn.nodeLabel[idx]
+			if gpn.labelMatches(s, i, mlen, label) {
+				gpn.recordHit(curnode, idx)
+				i += len(label) - 1
+				curnode = idx
+				continue
+			}
+			return false, params
+		}
+
+		idx, found := gpn.matchChild(curnode, part)
+		if !found {
+			return false, params
+		}
+		label := gpn.nodeLabel[idx]
+		if !gpn.labelMatches(s, i, mlen, label) {
+			return false, params
+		}
+		gpn.recordHit(curnode, idx)
+		i += len(label) - 1
+		curnode = idx
+	}
+
+	return gpn.nodeAttrs[curnode]&(isGlob|canMatch) != 0, params
+}
+
+func (gpn *globPathNode) globConsumeCapture(s string, index, mlen, nodeIndex int, params []Param) (bool, []Param) {
+	curnode := nodeIndex
+
+	if gpn.nodeAttrs[curnode]&canMatch != 0 {
+		return true, params
+	}
+
+	// see the matching early return in globConsume: a catch-all child
+	// always matches the remainder of the string.
+	if gpn.nodeAttrs[curnode]&hasCatchAllChild != 0 {
+		for _, idx := range gpn.children(curnode) {
+			if gpn.nodeChars[idx] == catchAllChar {
+				gpn.recordHit(curnode, idx)
+				captured := append(append([]Param(nil), params...), Param{Key: gpn.nodeParams[idx], Value: s[index:mlen]})
+				return true, captured
+			}
+		}
+	}
+
+	for i := index; i < mlen; i++ {
+		part := foldCase(uint8(s[i]), gpn.icase)
+
+		// see the matching comment in globConsume: this skip only applies
+		// when the sole child is a literal byte to compare against part.
+		children := gpn.children(curnode)
+		if gpn.nodeAttrs[curnode]&oneShot != 0 && len(children) > 0 {
+			idx := children[0]
+			if gpn.nodeLabel[idx] != nil && part != gpn.nodeChars[idx] {
+				continue
+			}
+		}
+
+		if idx, found := gpn.matchChild(curnode, part); found {
+			label := gpn.nodeLabel[idx]
+			if gpn.labelMatches(s, i, mlen, label) {
+				if ok, p := gpn.checkPathCaptureNode(s, i+len(label), mlen, idx, params); ok {
+					gpn.recordHit(curnode, idx)
+					return true, p
+				}
+			}
+		}
+
+		for _, idx := range gpn.children(curnode) {
+			switch gpn.nodeChars[idx] {
+			case singleChar:
+				if ok, p := gpn.checkPathCaptureNode(s, i+1, mlen, idx, params); ok {
+					gpn.recordHit(curnode, idx)
+					return true, p
+				}
+			case classChar:
+				if gpn.nodeClasses[idx].has(part) {
+					if ok, p := gpn.checkPathCaptureNode(s, i+1, mlen, idx, params); ok {
+						gpn.recordHit(curnode, idx)
+						return true, p
+					}
+				}
+			}
+		}
+
+		// see the matching block in globConsume: try a `:name` child at
+		// each position the glob could plausibly stop.
+		if gpn.nodeAttrs[curnode]&hasParamChild != 0 {
+			for _, idx := range gpn.children(curnode) {
+				if gpn.nodeChars[idx] == paramChar {
+					end := i
+					for end < mlen && s[end] != '/' {
+						end++
+					}
+					if end == i {
+						continue
+					}
+					captured := append(append([]Param(nil), params...), Param{Key: gpn.nodeParams[idx], Value: s[i:end]})
+					if ok, p := gpn.checkPathCaptureNode(s, end, mlen, idx, captured); ok {
+						gpn.recordHit(curnode, idx)
+						return true, p
+					}
+				}
+			}
+		}
+
+		if i == mlen-1 {
+			return gpn.nodeAttrs[curnode]&canMatch != 0, params
+		}
+	}
+
+	return false, params
+}
+
+// newGlobPathNode creates an empty trie. adaptive enables frequency-adaptive
+// child ordering (see recordHit/resortChildren); disable it for benchmarks
+// and tests that need deterministic sibling order. captures enables the
+// `:name`/`*name` grammar (see addPath); it defaults off in spirit as well
+// as in the zero value, so a caller has to opt in before a `:` or a bare-`*`
+// suffix in their existing ruleset can ever be parsed differently than it
+// was before named captures existed.
+func newGlobPathNode(icase bool, adaptive bool, captures bool) *globPathNode {
 	// refs for valid tree chars
 	// https://www.w3.org/TR/2011/WD-html5-20110525/urls.html (refers to RFC 3986)
 	// https://en.wikipedia.org/wiki/Uniform_Resource_Identifier#Generic_syntax
@@ -284,9 +1179,15 @@ func newGlobPathNode(icase bool) *globPathNode {
 	// and since we only /really/ care about lookup costs, just start with 0 initial
 	// map size and let it grow as needed
 	return &globPathNode{
-		nodeChars: []uint8{0},
-		nodeTree:  [][]int{{}},
-		nodeAttrs: []BitMask{0},
-		icase:     icase,
+		nodeChars:   []uint8{0},
+		nodeTree:    []childSet{{}},
+		nodeAttrs:   []BitMask{0},
+		nodeLabel:   [][]byte{nil},
+		nodeClasses: []*classSet{nil},
+		nodeParams:  []string{""},
+		nodeHits:    []uint32{0},
+		icase:       icase,
+		adaptive:    adaptive,
+		captures:    captures,
 	}
 }
@@ -0,0 +1,102 @@
+// Copyright (c) 2012-2019 Eli Janssen
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package htrie
+
+import "testing"
+
+func newTestNode(rules ...string) (*globPathNode, error) {
+	gpn := newGlobPathNode(false, false, true)
+	for _, r := range rules {
+		if err := gpn.addPath(r); err != nil {
+			return nil, err
+		}
+	}
+	return gpn, nil
+}
+
+func TestCheckPathGlob(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []string
+		path  string
+		want  bool
+	}{
+		{"plain literal match", []string{"foo/bar"}, "foo/bar", true},
+		{"plain literal mismatch", []string{"foo/bar"}, "foo/baz", false},
+		{"bare glob matches anything after prefix", []string{"foo/*"}, "foo/anything/else", true},
+		{"bare glob requires prefix", []string{"foo/*"}, "bar/anything", false},
+		// regression: a glob immediately followed by a single `?` or a
+		// bracket class used to never match, because the oneShot
+		// short-circuit compared the literal path byte against the
+		// sentinel nodeChars value of the `?`/class child instead of
+		// falling through to the class/single-char handling.
+		{"glob then single-char wildcard", []string{"x*?"}, "xab", true},
+		{"glob then single-char wildcard, longer run", []string{"x*?"}, "xabc", true},
+		{"glob then single-char wildcard, too short", []string{"x*?"}, "x", false},
+		{"glob then bracket class", []string{"x*[0-9]"}, "xabc5", true},
+		{"glob then bracket class, no digit", []string{"x*[0-9]"}, "xabc", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gpn, err := newTestNode(tt.rules...)
+			if err != nil {
+				t.Fatalf("addPath: %v", err)
+			}
+			if got := gpn.checkPath(tt.path, 0, len(tt.path), 0); got != tt.want {
+				t.Errorf("checkPath(%q) with rules %v = %v, want %v", tt.path, tt.rules, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCheckPathClassVsBrace guards against findBraceGroup/splitUnescaped
+// mistaking a `{`, `}`, or `,` inside a `[...]` bracket class for a
+// brace-alternation delimiter.
+func TestCheckPathClassVsBrace(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []string
+		path  string
+		want  bool
+	}{
+		// regression: "[{]" is a one-character class matching a literal
+		// '{', but findBraceGroup used to see the unescaped '{' inside the
+		// class and report "unterminated brace group".
+		{"class containing a literal brace", []string{"foo[{]bar"}, "foo{bar", true},
+		{"class containing a literal brace, mismatch", []string{"foo[{]bar"}, "foobar", false},
+		// regression: splitUnescaped used to split the brace group's
+		// content on the comma inside "[a,b]", turning one alternative
+		// into two malformed ones ("[a" and "b]").
+		{"brace alternation with a class containing a comma", []string{"foo{[a,b],c}bar"}, "fooabar", true},
+		{"brace alternation with a class containing a comma, comma itself", []string{"foo{[a,b],c}bar"}, "foo,bar", true},
+		{"brace alternation with a class containing a comma, other alt", []string{"foo{[a,b],c}bar"}, "foocbar", true},
+		{"brace alternation with a class containing a comma, no match", []string{"foo{[a,b],c}bar"}, "fooxbar", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gpn, err := newTestNode(tt.rules...)
+			if err != nil {
+				t.Fatalf("addPath: %v", err)
+			}
+			if got := gpn.checkPath(tt.path, 0, len(tt.path), 0); got != tt.want {
+				t.Errorf("checkPath(%q) with rules %v = %v, want %v", tt.path, tt.rules, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestAddPathRejectsCatchAllNotLast guards against a catch-all silently
+// widening the operator's intended rule: `*name` always consumes through
+// the end of the string, so a pattern like "foo/*rest/bar" can never
+// actually require the trailing "/bar" -- addPath must reject it outright
+// rather than build a trie node that checkPath would have to special-case.
+func TestAddPathRejectsCatchAllNotLast(t *testing.T) {
+	gpn := newGlobPathNode(false, false, true)
+	if err := gpn.addPath("foo/*rest/bar"); err == nil {
+		t.Fatal("addPath(\"foo/*rest/bar\") = nil error, want a rejection")
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright (c) 2012-2019 Eli Janssen
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package htrie
+
+import "testing"
+
+// TestCheckPathParam covers basic `:name` segment-boundary matching and
+// `*name` catch-all matching, independent of whether captures are returned.
+func TestCheckPathParam(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []string
+		path  string
+		want  bool
+	}{
+		{"param matches one segment", []string{"user/:id"}, "user/42", true},
+		{"param does not cross a slash", []string{"user/:id"}, "user/42/extra", false},
+		{"param requires a non-empty segment", []string{"user/:id"}, "user/", false},
+		{"catch-all matches the rest of the string", []string{"static/*rest"}, "static/css/app.css", true},
+		{"catch-all matches a single segment too", []string{"static/*rest"}, "static/app.css", true},
+		{"catch-all requires at least the prefix", []string{"static/*rest"}, "other/app.css", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gpn, err := newTestNode(tt.rules...)
+			if err != nil {
+				t.Fatalf("addPath: %v", err)
+			}
+			if got := gpn.checkPath(tt.path, 0, len(tt.path), 0); got != tt.want {
+				t.Errorf("checkPath(%q) with rules %v = %v, want %v", tt.path, tt.rules, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCheckPathCaptureSingle exercises checkPathCapture's returned []Param
+// for a single `:name`/`*name` capture.
+func TestCheckPathCaptureSingle(t *testing.T) {
+	tests := []struct {
+		name      string
+		rule      string
+		path      string
+		wantOK    bool
+		wantKey   string
+		wantValue string
+	}{
+		{"named segment capture", "user/:id", "user/42", true, "id", "42"},
+		{"catch-all capture", "static/*rest", "static/css/app.css", true, "rest", "css/app.css"},
+		{"no match, no capture", "user/:id", "other/42", false, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gpn, err := newTestNode(tt.rule)
+			if err != nil {
+				t.Fatalf("addPath: %v", err)
+			}
+			ok, params := gpn.checkPathCapture(tt.path)
+			if ok != tt.wantOK {
+				t.Fatalf("checkPathCapture(%q) ok = %v, want %v", tt.path, ok, tt.wantOK)
+			}
+			if !tt.wantOK {
+				if len(params) != 0 {
+					t.Errorf("checkPathCapture(%q) params = %v, want none", tt.path, params)
+				}
+				return
+			}
+			if len(params) != 1 || params[0].Key != tt.wantKey || params[0].Value != tt.wantValue {
+				t.Errorf("checkPathCapture(%q) params = %v, want [{%s %s}]", tt.path, params, tt.wantKey, tt.wantValue)
+			}
+		})
+	}
+}
+
+// TestCheckPathCaptureMultiple covers more than one named capture along a
+// single matching path, in the order they appear in the rule.
+func TestCheckPathCaptureMultiple(t *testing.T) {
+	gpn, err := newTestNode("user/:uid/avatar/:size")
+	if err != nil {
+		t.Fatalf("addPath: %v", err)
+	}
+
+	ok, params := gpn.checkPathCapture("user/7/avatar/large")
+	if !ok {
+		t.Fatal("checkPathCapture = false, want true")
+	}
+	want := []Param{{Key: "uid", Value: "7"}, {Key: "size", Value: "large"}}
+	if len(params) != len(want) {
+		t.Fatalf("checkPathCapture params = %v, want %v", params, want)
+	}
+	for i, p := range want {
+		if params[i] != p {
+			t.Errorf("checkPathCapture params[%d] = %v, want %v", i, params[i], p)
+		}
+	}
+}
+
+// TestCheckPathParamVsLiteralPrecedence guards the precedence a param-named
+// sibling has relative to a literal sibling at the same trie level:
+// hasParamChild is tried before the literal oneShot/map fallback (see
+// checkPath/checkPathCaptureNode), so the param branch wins whenever it
+// matches at all, even when a literal sibling would also have matched.
+func TestCheckPathParamVsLiteralPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want bool
+	}{
+		{"path only the literal sibling can match", "user/new/profile", true},
+		{"path either sibling could match, param wins", "user/new", true},
+		{"path only the param sibling can match", "user/42", true},
+	}
+
+	gpn, err := newTestNode("user/new/profile", "user/new", "user/:id")
+	if err != nil {
+		t.Fatalf("addPath: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := gpn.checkPath(tt.path, 0, len(tt.path), 0); got != tt.want {
+				t.Errorf("checkPath(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+
+	ok, params := gpn.checkPathCapture("user/42")
+	if !ok || len(params) != 1 || params[0].Key != "id" || params[0].Value != "42" {
+		t.Errorf("checkPathCapture(\"user/42\") = %v, %v, want captured id=42", ok, params)
+	}
+
+	ok, params = gpn.checkPathCapture("user/new")
+	if !ok || len(params) != 1 || params[0].Key != "id" || params[0].Value != "new" {
+		t.Errorf("checkPathCapture(\"user/new\") = %v, %v, want the param branch to win with id=new", ok, params)
+	}
+
+	ok, params = gpn.checkPathCapture("user/new/profile")
+	if !ok || len(params) != 0 {
+		t.Errorf("checkPathCapture(\"user/new/profile\") = %v, %v, want a literal match with no captures", ok, params)
+	}
+}
+
+// TestCaptureDisambiguationDisabledByDefault guards against named captures
+// silently reinterpreting rules written against the original bare-glob
+// grammar, where `*` is always a plain wildcard and `:` is always a literal
+// byte. With captures off (the globPathNode zero value, and what this test
+// passes to newGlobPathNode), a pre-existing rule
+// like "example.com/*css" (glob, then literal "css") or "example.com:8080/*"
+// (a literal port number, then a glob) must keep matching exactly as it did
+// before `:name`/`*name` existed.
+func TestCaptureDisambiguationDisabledByDefault(t *testing.T) {
+	gpn := newGlobPathNode(false, false, false)
+	if err := gpn.addPath("example.com/*css"); err != nil {
+		t.Fatalf("addPath: %v", err)
+	}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"example.com/foo.js", false},
+		{"example.com/anything", false},
+		{"example.com/foocss", true},
+	}
+	for _, tt := range tests {
+		if got := gpn.checkPath(tt.path, 0, len(tt.path), 0); got != tt.want {
+			t.Errorf("checkPath(%q) with captures disabled = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	portGpn := newGlobPathNode(false, false, false)
+	if err := portGpn.addPath("example.com:8080/*"); err != nil {
+		t.Fatalf("addPath: %v", err)
+	}
+	if portGpn.checkPath("example.com:9999/images/foo.png", 0, len("example.com:9999/images/foo.png"), 0) {
+		t.Error(`checkPath("example.com:9999/images/foo.png") = true, want false (the literal port must not become a param)`)
+	}
+	if !portGpn.checkPath("example.com:8080/images/foo.png", 0, len("example.com:8080/images/foo.png"), 0) {
+		t.Error(`checkPath("example.com:8080/images/foo.png") = false, want true`)
+	}
+}
+
+// TestCaptureDisambiguationSegmentBoundary covers the same `:`/`*`
+// mid-segment ambiguity with captures turned on: even then, `:`/`*name` are
+// only recognized as named captures at the start of a path segment, so a
+// literal colon embedded in a hostname (e.g. a port number) still can't
+// become a param.
+func TestCaptureDisambiguationSegmentBoundary(t *testing.T) {
+	gpn := newGlobPathNode(false, false, true)
+	if err := gpn.addPath("example.com:8080/*"); err != nil {
+		t.Fatalf("addPath: %v", err)
+	}
+	if gpn.checkPath("example.com:9999/images/foo.png", 0, len("example.com:9999/images/foo.png"), 0) {
+		t.Error(`checkPath("example.com:9999/images/foo.png") = true, want false (":8080" is mid-segment, not a param)`)
+	}
+	if !gpn.checkPath("example.com:8080/images/foo.png", 0, len("example.com:8080/images/foo.png"), 0) {
+		t.Error(`checkPath("example.com:8080/images/foo.png") = false, want true`)
+	}
+
+	// A param at an actual segment boundary still works with captures on.
+	gpn2 := newGlobPathNode(false, false, true)
+	if err := gpn2.addPath("user/:id"); err != nil {
+		t.Fatalf("addPath: %v", err)
+	}
+	if !gpn2.checkPath("user/42", 0, len("user/42"), 0) {
+		t.Error(`checkPath("user/42") = false, want true`)
+	}
+}
+
+// TestCheckPathCaptureAfterGlob covers a `:name`/`*name` immediately
+// following a bare `*` glob with no literal `/` in between -- addPath accepts
+// "static/*:id" and "static/**rest" without error, and globConsume/
+// globConsumeCapture must actually try the param/catch-all child a glob node
+// carries, the same way checkPath/checkPathCaptureNode already do at the top
+// level.
+func TestCheckPathCaptureAfterGlob(t *testing.T) {
+	gpn, err := newTestNode("static/*:id")
+	if err != nil {
+		t.Fatalf("addPath: %v", err)
+	}
+	if !gpn.checkPath("static/42", 0, len("static/42"), 0) {
+		t.Error(`checkPath("static/42") = false, want true`)
+	}
+	ok, params := gpn.checkPathCapture("static/42")
+	if !ok || len(params) != 1 || params[0].Key != "id" || params[0].Value != "42" {
+		t.Errorf(`checkPathCapture("static/42") = %v, %v, want captured id=42`, ok, params)
+	}
+
+	catchAllGpn, err := newTestNode("static/**rest")
+	if err != nil {
+		t.Fatalf("addPath: %v", err)
+	}
+	if !catchAllGpn.checkPath("static/foo/bar", 0, len("static/foo/bar"), 0) {
+		t.Error(`checkPath("static/foo/bar") = false, want true`)
+	}
+	ok, params = catchAllGpn.checkPathCapture("static/foo/bar")
+	if !ok || len(params) != 1 || params[0].Key != "rest" || params[0].Value != "foo/bar" {
+		t.Errorf(`checkPathCapture("static/foo/bar") = %v, %v, want captured rest=foo/bar`, ok, params)
+	}
+}
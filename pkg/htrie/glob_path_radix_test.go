@@ -0,0 +1,93 @@
+// Copyright (c) 2012-2019 Eli Janssen
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package htrie
+
+import "testing"
+
+// TestRadixCompressionNodeCount checks that a run of literal bytes with no
+// branching is stored as a single node, not one node per byte.
+func TestRadixCompressionNodeCount(t *testing.T) {
+	gpn, err := newTestNode("abcdefgh")
+	if err != nil {
+		t.Fatalf("addPath: %v", err)
+	}
+	// root node + one label node for the whole literal run.
+	if got, want := len(gpn.nodeChars), 2; got != want {
+		t.Errorf("node count = %d, want %d (radix compression not applied)", got, want)
+	}
+	if !gpn.checkPath("abcdefgh", 0, 8, 0) {
+		t.Error("checkPath(\"abcdefgh\") = false, want true")
+	}
+}
+
+// TestRadixCompressionSplit exercises the common-prefix split that happens
+// when a later addPath call diverges partway through an existing label.
+func TestRadixCompressionSplit(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []string
+		path  string
+		want  bool
+	}{
+		{"shared prefix, first branch", []string{"foobar", "foobaz"}, "foobar", true},
+		{"shared prefix, second branch", []string{"foobar", "foobaz"}, "foobaz", true},
+		{"shared prefix, no such branch", []string{"foobar", "foobaz"}, "foobax", false},
+		{"one path is a prefix of another, shorter", []string{"foo", "foobar"}, "foo", true},
+		{"one path is a prefix of another, longer", []string{"foo", "foobar"}, "foobar", true},
+		{"one path is a prefix of another, neither", []string{"foo", "foobar"}, "foob", false},
+		{"three-way split", []string{"team", "teapot", "tea"}, "teapot", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gpn, err := newTestNode(tt.rules...)
+			if err != nil {
+				t.Fatalf("addPath: %v", err)
+			}
+			if got := gpn.checkPath(tt.path, 0, len(tt.path), 0); got != tt.want {
+				t.Errorf("checkPath(%q) with rules %v = %v, want %v", tt.path, tt.rules, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRadixSplitKeepsOneShot guards against a regression in
+// splitLabelNode: splitting a label used to hardcode the prefix node's
+// attrs to 0 and assign its children directly, bypassing the bookkeeping
+// attachChild does to set oneShot for a lone child. A prefix node left with
+// exactly one child (the new tail) must still get oneShot, or every split
+// permanently falls back to the slow map-lookup path.
+func TestRadixSplitKeepsOneShot(t *testing.T) {
+	gpn, err := newTestNode("foobar", "fooba")
+	if err != nil {
+		t.Fatalf("addPath: %v", err)
+	}
+
+	children := gpn.children(0)
+	if len(children) != 1 {
+		t.Fatalf("root has %d children, want 1 (expected a single shared-prefix node)", len(children))
+	}
+	prefixIdx := children[0]
+	if string(gpn.nodeLabel[prefixIdx]) != "fooba" {
+		t.Fatalf("prefix node label = %q, want %q", gpn.nodeLabel[prefixIdx], "fooba")
+	}
+	if len(gpn.children(prefixIdx)) != 1 {
+		t.Fatalf("prefix node has %d children, want 1", len(gpn.children(prefixIdx)))
+	}
+	if gpn.nodeAttrs[prefixIdx]&oneShot == 0 {
+		t.Error("prefix node after split: oneShot not set, want set")
+	}
+
+	for path, want := range map[string]bool{
+		"foobar": true,
+		"fooba":  true,
+		"foobax": false,
+		"fo":     false,
+	} {
+		if got := gpn.checkPath(path, 0, len(path), 0); got != want {
+			t.Errorf("checkPath(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
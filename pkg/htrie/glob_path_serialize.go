@@ -0,0 +1,345 @@
+// Copyright (c) 2012-2019 Eli Janssen
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package htrie
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+)
+
+// On-disk format for a compiled globPathNode trie:
+//
+//	[0:4]   magic      uint32 BE  ("GPN1")
+//	[4]     version    uint8
+//	[5]     flags      uint8      (bit0 icase, bit1 adaptive, bit2 captures)
+//	[6:8]   reserved   (zero)
+//	[8:16]  nodeCount  uint64 BE
+//	[16:24] sourceHash uint64 BE  (see HashRuleset/SetSourceHash)
+//	-- then, nodeCount entries of each, in this order --
+//	nodeChars:   1 byte each
+//	nodeAttrs:   2 bytes each (BitMask, BE)
+//	nodeLabel:   uvarint length + raw bytes (length 0 == nil)
+//	nodeClasses: 1 byte present flag, then 32 bytes (4x uint64 BE) if present
+//	nodeParams:  uvarint length + raw bytes (length 0 == "")
+//	nodeTree:    uvarint child count, then that many uvarint child indices
+//
+// nodeHits is intentionally not persisted: it's a runtime-only heuristic
+// that naturally re-accumulates from a cold start.
+const (
+	globPathMagic     uint32 = 0x47504e31 // "GPN1"
+	globPathVersion   uint8  = 1
+	globPathHeaderLen        = 24
+)
+
+type serializeFlags uint8
+
+const (
+	flagICase serializeFlags = 1 << iota
+	flagAdaptive
+	flagCaptures
+)
+
+// HashRuleset returns a content hash of a ruleset's raw bytes. Store it via
+// SetSourceHash before MarshalBinary, and compare it against the SourceHash()
+// of the checker LoadGlobPathChecker returns, so a stale cache on disk can be
+// detected without rebuilding the trie from the ruleset. The stored hash is
+// only available once the file has been loaded and decoded.
+func HashRuleset(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+	return h.Sum64()
+}
+
+// SetSourceHash records the hash of the ruleset this trie was built from,
+// so it round-trips through MarshalBinary/UnmarshalBinary.
+func (gpn *globPathNode) SetSourceHash(h uint64) {
+	gpn.sourceHash = h
+}
+
+// SourceHash returns the hash recorded by SetSourceHash (zero if never set).
+func (gpn *globPathNode) SourceHash() uint64 {
+	return gpn.sourceHash
+}
+
+// MarshalBinary dumps the compiled trie into the compact format described
+// above, so it can be written to disk and reloaded with LoadGlobPathChecker
+// instead of re-parsing the source ruleset on every startup.
+func (gpn *globPathNode) MarshalBinary() ([]byte, error) {
+	if gpn == nil {
+		return nil, fmt.Errorf("got nil <gpn> in receiver")
+	}
+
+	var buf bytes.Buffer
+
+	var header [globPathHeaderLen]byte
+	binary.BigEndian.PutUint32(header[0:4], globPathMagic)
+	header[4] = globPathVersion
+	var flags serializeFlags
+	if gpn.icase {
+		flags |= flagICase
+	}
+	if gpn.adaptive {
+		flags |= flagAdaptive
+	}
+	if gpn.captures {
+		flags |= flagCaptures
+	}
+	header[5] = byte(flags)
+	binary.BigEndian.PutUint64(header[8:16], uint64(len(gpn.nodeChars)))
+	binary.BigEndian.PutUint64(header[16:24], gpn.sourceHash)
+	buf.Write(header[:])
+
+	buf.Write(gpn.nodeChars)
+
+	var u16 [2]byte
+	for _, attr := range gpn.nodeAttrs {
+		binary.BigEndian.PutUint16(u16[:], uint16(attr))
+		buf.Write(u16[:])
+	}
+
+	for _, label := range gpn.nodeLabel {
+		writeUvarint(&buf, uint64(len(label)))
+		buf.Write(label)
+	}
+
+	var u64 [8]byte
+	for _, cs := range gpn.nodeClasses {
+		if cs == nil {
+			buf.WriteByte(0)
+			continue
+		}
+		buf.WriteByte(1)
+		for _, word := range cs {
+			binary.BigEndian.PutUint64(u64[:], word)
+			buf.Write(u64[:])
+		}
+	}
+
+	for _, name := range gpn.nodeParams {
+		writeUvarint(&buf, uint64(len(name)))
+		buf.WriteString(name)
+	}
+
+	for i := range gpn.nodeTree {
+		children := gpn.children(i)
+		writeUvarint(&buf, uint64(len(children)))
+		for _, c := range children {
+			writeUvarint(&buf, uint64(c))
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary reconstructs a trie previously produced by MarshalBinary.
+// nodeHits starts fresh (see the format notes above).
+func (gpn *globPathNode) UnmarshalBinary(data []byte) error {
+	if gpn == nil {
+		return fmt.Errorf("got nil <gpn> in receiver")
+	}
+	if len(data) < globPathHeaderLen {
+		return fmt.Errorf("htrie: truncated trie data (%d bytes)", len(data))
+	}
+	if binary.BigEndian.Uint32(data[0:4]) != globPathMagic {
+		return fmt.Errorf("htrie: bad magic in trie data")
+	}
+	if data[4] != globPathVersion {
+		return fmt.Errorf("htrie: unsupported trie format version %d", data[4])
+	}
+	flags := serializeFlags(data[5])
+	nodeCount := binary.BigEndian.Uint64(data[8:16])
+	sourceHash := binary.BigEndian.Uint64(data[16:24])
+
+	r := bytes.NewReader(data[globPathHeaderLen:])
+
+	nodeChars := make([]uint8, nodeCount)
+	if _, err := io.ReadFull(r, nodeChars); err != nil {
+		return fmt.Errorf("htrie: reading nodeChars: %w", err)
+	}
+
+	nodeAttrs := make([]BitMask, nodeCount)
+	var u16 [2]byte
+	for i := range nodeAttrs {
+		if _, err := io.ReadFull(r, u16[:]); err != nil {
+			return fmt.Errorf("htrie: reading nodeAttrs: %w", err)
+		}
+		nodeAttrs[i] = BitMask(binary.BigEndian.Uint16(u16[:]))
+	}
+
+	nodeLabel := make([][]byte, nodeCount)
+	for i := range nodeLabel {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return fmt.Errorf("htrie: reading label length: %w", err)
+		}
+		if n == 0 {
+			continue
+		}
+		label := make([]byte, n)
+		if _, err := io.ReadFull(r, label); err != nil {
+			return fmt.Errorf("htrie: reading label: %w", err)
+		}
+		nodeLabel[i] = label
+	}
+
+	nodeClasses := make([]*classSet, nodeCount)
+	var u64 [8]byte
+	for i := range nodeClasses {
+		present, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("htrie: reading class flag: %w", err)
+		}
+		if present == 0 {
+			continue
+		}
+		var cs classSet
+		for w := range cs {
+			if _, err := io.ReadFull(r, u64[:]); err != nil {
+				return fmt.Errorf("htrie: reading class bitmap: %w", err)
+			}
+			cs[w] = binary.BigEndian.Uint64(u64[:])
+		}
+		nodeClasses[i] = &cs
+	}
+
+	nodeParams := make([]string, nodeCount)
+	for i := range nodeParams {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return fmt.Errorf("htrie: reading param length: %w", err)
+		}
+		if n == 0 {
+			continue
+		}
+		name := make([]byte, n)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return fmt.Errorf("htrie: reading param name: %w", err)
+		}
+		nodeParams[i] = string(name)
+	}
+
+	nodeTree := make([]childSet, nodeCount)
+	for i := range nodeTree {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return fmt.Errorf("htrie: reading child count: %w", err)
+		}
+		if n == 0 {
+			continue
+		}
+		children := make([]int, n)
+		for j := range children {
+			c, err := binary.ReadUvarint(r)
+			if err != nil {
+				return fmt.Errorf("htrie: reading child index: %w", err)
+			}
+			children[j] = int(c)
+		}
+		nodeTree[i].Store(&children)
+	}
+
+	gpn.nodeChars = nodeChars
+	gpn.nodeAttrs = nodeAttrs
+	gpn.nodeLabel = nodeLabel
+	gpn.nodeClasses = nodeClasses
+	gpn.nodeParams = nodeParams
+	gpn.nodeTree = nodeTree
+	gpn.nodeHits = make([]uint32, nodeCount)
+	gpn.icase = flags&flagICase != 0
+	gpn.adaptive = flags&flagAdaptive != 0
+	gpn.captures = flags&flagCaptures != 0
+	gpn.sourceHash = sourceHash
+	return nil
+}
+
+// writeUvarint appends x to buf as an unsigned varint.
+func writeUvarint(buf *bytes.Buffer, x uint64) {
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(scratch[:], x)
+	buf.Write(scratch[:n])
+}
+
+// GlobPathChecker is the handle Compile and LoadGlobPathChecker hand back to
+// callers: a compiled trie plus the read-only operations a consumer needs to
+// use it -- checking a path and reading back its source hash. It
+// deliberately doesn't expose addPath/newGlobPathNode directly; building a
+// trie from a ruleset goes through Compile instead.
+type GlobPathChecker struct {
+	gpn *globPathNode
+}
+
+// Compile builds a trie from rules and returns a ready-to-use
+// GlobPathChecker along with its MarshalBinary-encoded form, so a caller can
+// check paths immediately and also write the bytes to disk for a later,
+// faster start via LoadGlobPathChecker. captures enables the `:name`/`*name`
+// grammar (see addPath) for every rule; leave it off for a plain allow/deny
+// list so a literal `:` or a `*`-then-identifier suffix keeps its original
+// meaning. sourceHash is recorded on the trie before encoding (see
+// HashRuleset/SetSourceHash), so a caller that later loads the bytes with
+// LoadGlobPathChecker can compare GlobPathChecker.SourceHash() against a
+// freshly hashed ruleset and detect a stale on-disk cache.
+func Compile(rules []string, icase, adaptive, captures bool, sourceHash uint64) (*GlobPathChecker, []byte, error) {
+	gpn := newGlobPathNode(icase, adaptive, captures)
+	for _, rule := range rules {
+		if err := gpn.addPath(rule); err != nil {
+			return nil, nil, fmt.Errorf("htrie: compiling rule %q: %w", rule, err)
+		}
+	}
+	gpn.SetSourceHash(sourceHash)
+
+	data, err := gpn.MarshalBinary()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &GlobPathChecker{gpn: gpn}, data, nil
+}
+
+// CheckPath reports whether path matches any rule compiled into the trie.
+func (c *GlobPathChecker) CheckPath(path string) bool {
+	return c.gpn.checkPath(path, 0, len(path), 0)
+}
+
+// CheckPathCapture behaves like CheckPath, but also returns the named
+// `:param`/`*catchall` captures collected along the matching path.
+func (c *GlobPathChecker) CheckPathCapture(path string) (bool, []Param) {
+	return c.gpn.checkPathCapture(path)
+}
+
+// SourceHash returns the hash SetSourceHash recorded on the trie before it
+// was encoded (zero if never set). For a checker from LoadGlobPathChecker it
+// is read from the loaded file, so comparing it with HashRuleset of the
+// current ruleset tells whether that file is stale.
+func (c *GlobPathChecker) SourceHash() uint64 {
+	return c.gpn.SourceHash()
+}
+
+// LoadGlobPathChecker reads the compiled trie at path, decodes it, and wraps
+// it in a GlobPathChecker.
+//
+// This used to mmap the file and decode straight from the mapping, but that
+// bought nothing: UnmarshalBinary still allocates a fresh []byte for every
+// node's label and param name (same cost as decoding a plain read() buffer),
+// and syscall.Mmap/Munmap are Unix-only, so the mmap path also broke the
+// build on Windows. A plain read is just as fast for the allocation profile
+// this actually has, and it works everywhere os.ReadFile does.
+func LoadGlobPathChecker(path string) (*GlobPathChecker, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < globPathHeaderLen {
+		return nil, fmt.Errorf("htrie: %q is too small to be a compiled trie", path)
+	}
+
+	gpn := &globPathNode{}
+	if err := gpn.UnmarshalBinary(data); err != nil {
+		return nil, fmt.Errorf("htrie: loading %q: %w", path, err)
+	}
+	return &GlobPathChecker{gpn: gpn}, nil
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2012-2019 Eli Janssen
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package htrie
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMarshalUnmarshalRoundTrip(t *testing.T) {
+	gpn := newGlobPathNode(true, true, true)
+	rules := []string{
+		"example.com/foo/*",
+		"example.com/bar/:id",
+		"example.com/baz/*rest",
+		"example.com/qux/[a-z]?",
+	}
+	for _, r := range rules {
+		if err := gpn.addPath(r); err != nil {
+			t.Fatalf("addPath(%q): %v", r, err)
+		}
+	}
+	gpn.SetSourceHash(HashRuleset([]byte("some ruleset")))
+
+	data, err := gpn.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+
+	loaded := &globPathNode{}
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+
+	if loaded.SourceHash() != gpn.SourceHash() {
+		t.Errorf("SourceHash() = %d, want %d", loaded.SourceHash(), gpn.SourceHash())
+	}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"example.com/foo/anything", true},
+		{"example.com/bar/42", true},
+		{"example.com/baz/whatever/else", true},
+		{"EXAMPLE.COM/FOO/ANYTHING", true}, // icase round-tripped
+		{"example.com/qux/a1", true},
+		{"example.com/nope", false},
+	}
+	for _, tt := range tests {
+		if got := loaded.checkPath(tt.path, 0, len(tt.path), 0); got != tt.want {
+			t.Errorf("checkPath(%q) after round-trip = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	ok, params := loaded.checkPathCapture("example.com/bar/42")
+	if !ok || len(params) != 1 || params[0].Key != "id" || params[0].Value != "42" {
+		t.Errorf("checkPathCapture after round-trip = %v, %v, want captured id=42", ok, params)
+	}
+}
+
+// TestCompileThenLoad exercises the full write side of the feature: Compile
+// builds a trie from rules the way a caller outside this package would, and
+// the bytes it returns are exactly what LoadGlobPathChecker expects to read
+// back from disk.
+func TestCompileThenLoad(t *testing.T) {
+	rules := []string{"example.com/compiled/*"}
+	hash := HashRuleset([]byte("some ruleset"))
+
+	checker, data, err := Compile(rules, false, false, false, hash)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if !checker.CheckPath("example.com/compiled/ok") {
+		t.Error("CheckPath on freshly-compiled trie = false, want true")
+	}
+	if checker.SourceHash() != hash {
+		t.Errorf("SourceHash() = %d, want %d", checker.SourceHash(), hash)
+	}
+
+	path := filepath.Join(t.TempDir(), "trie.bin")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("writeFile: %v", err)
+	}
+
+	loaded, err := LoadGlobPathChecker(path)
+	if err != nil {
+		t.Fatalf("LoadGlobPathChecker: %v", err)
+	}
+	if !loaded.CheckPath("example.com/compiled/ok") {
+		t.Error("CheckPath on loaded trie = false, want true")
+	}
+	if loaded.SourceHash() != hash {
+		t.Errorf("loaded SourceHash() = %d, want %d", loaded.SourceHash(), hash)
+	}
+}
+
+func TestLoadGlobPathChecker(t *testing.T) {
+	gpn := newGlobPathNode(false, false, false)
+	if err := gpn.addPath("example.com/loaded/*"); err != nil {
+		t.Fatalf("addPath: %v", err)
+	}
+	data, err := gpn.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "trie.bin")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("writeFile: %v", err)
+	}
+
+	loaded, err := LoadGlobPathChecker(path)
+	if err != nil {
+		t.Fatalf("LoadGlobPathChecker: %v", err)
+	}
+	if !loaded.CheckPath("example.com/loaded/ok") {
+		t.Error("CheckPath on loaded trie = false, want true")
+	}
+}
+
+// TestGlobPathCheckerCaptureAndHash exercises the rest of the GlobPathChecker
+// surface -- CheckPathCapture and SourceHash -- through LoadGlobPathChecker.
+func TestGlobPathCheckerCaptureAndHash(t *testing.T) {
+	gpn := newGlobPathNode(false, false, true)
+	if err := gpn.addPath("example.com/bar/:id"); err != nil {
+		t.Fatalf("addPath: %v", err)
+	}
+	gpn.SetSourceHash(HashRuleset([]byte("some ruleset")))
+	data, err := gpn.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "trie.bin")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("writeFile: %v", err)
+	}
+
+	loaded, err := LoadGlobPathChecker(path)
+	if err != nil {
+		t.Fatalf("LoadGlobPathChecker: %v", err)
+	}
+
+	if got, want := loaded.SourceHash(), gpn.SourceHash(); got != want {
+		t.Errorf("SourceHash() = %d, want %d", got, want)
+	}
+
+	ok, params := loaded.CheckPathCapture("example.com/bar/42")
+	if !ok || len(params) != 1 || params[0].Key != "id" || params[0].Value != "42" {
+		t.Errorf("CheckPathCapture = %v, %v, want captured id=42", ok, params)
+	}
+}
+
+func TestLoadGlobPathCheckerTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trie.bin")
+	if err := os.WriteFile(path, []byte("too short"), 0o644); err != nil {
+		t.Fatalf("writeFile: %v", err)
+	}
+	if _, err := LoadGlobPathChecker(path); err == nil {
+		t.Fatal("LoadGlobPathChecker on truncated data = nil error, want a rejection")
+	}
+}